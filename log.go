@@ -3,24 +3,63 @@
 //
 // Log to stdout:
 //
-//    log := NewStdoutLog()
-//    log.Error("My name is: %s", name)
+//	log := NewStdoutLog()
+//	log.Error("My name is: %s", name)
 //
 // Log using syslog:
 //
-//    log, err := log.NewLog("myapp")
-//    if err != nil {
-//        fmt.Fprintln(os.Stderr, "Failure to setup syslog logging: %v", err)
-//        os.Exit(1)
-//    }
-//    log.Error("My name is: %s", name)
+//	log, err := log.NewLog("myapp")
+//	if err != nil {
+//	    fmt.Fprintf(os.Stderr, "Failure to setup syslog logging: %v\n", err)
+//	    os.Exit(1)
+//	}
+//	log.Error("My name is: %s", name)
 //
+// Structured fields can be attached to a logger with With(), and are
+// carried through to every backend. StdoutLog and SyslogLog render them
+// as "key=value" suffixes in text mode, or as a JSON object when Format
+// is set to FormatJSON:
+//
+//	log := NewJSONLog(os.Stdout)
+//	log.With("user_id", 42, "req_id", rid).Info("login")
+//
+// A logger can be stashed on a context.Context so that deeply nested
+// code can pick up the caller's fields without threading a *Log
+// parameter everywhere:
+//
+//	logger := NewJSONLog(os.Stdout).With("req_id", rid)
+//	ctx = NewContext(ctx, logger)
+//	...
+//	logger.InfoContext(ctx, "handled request")
 package log
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/syslog"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format selects how a backend renders a record: as the original
+// "LEVEL: message" text line, or as a single-line JSON object.
+type Format int
+
+const (
+	FormatText Format = iota
+	FormatJSON
 )
 
 type Log interface {
@@ -29,49 +68,301 @@ type Log interface {
 	Notice(format string, a ...interface{}) error
 	Warning(format string, a ...interface{}) error
 	Error(format string, a ...interface{}) error
+
+	DebugContext(ctx context.Context, format string, a ...interface{}) error
+	InfoContext(ctx context.Context, format string, a ...interface{}) error
+	NoticeContext(ctx context.Context, format string, a ...interface{}) error
+	WarningContext(ctx context.Context, format string, a ...interface{}) error
+	ErrorContext(ctx context.Context, format string, a ...interface{}) error
+
+	// With returns a copy of this logger with the given key/value pairs
+	// merged into its structured fields. Keys are expected to be strings;
+	// a non-string key is rendered with fmt.Sprintf("%v", key). An odd
+	// number of arguments drops the trailing key.
+	With(keyvals ...interface{}) Log
+
 	Close()
 }
 
+// ctxKey is an unexported type so values stored by this package can
+// never collide with keys set by other packages using context.WithValue.
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying l, retrievable with
+// FromContext. It's typically used to stash a logger that already has
+// request-scoped fields attached via With.
+func NewContext(ctx context.Context, l Log) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the Log previously stored in ctx with NewContext,
+// if any.
+func FromContext(ctx context.Context) (Log, bool) {
+	l, ok := ctx.Value(ctxKey{}).(Log)
+	return l, ok
+}
+
+// fieldCarrier is implemented by loggers that expose their structured
+// fields, so a *Context method can pick up fields attached to a logger
+// stashed on ctx without adopting that logger's backend wholesale.
+type fieldCarrier interface {
+	fieldsMap() map[string]interface{}
+}
+
+// contextFields returns the fields of the logger stored in ctx via
+// NewContext, if any, and if it exposes them.
+func contextFields(ctx context.Context) map[string]interface{} {
+	cl, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	fc, ok := cl.(fieldCarrier)
+	if !ok {
+		return nil
+	}
+	return fc.fieldsMap()
+}
+
+// callerSkipAdjuster is implemented by loggers that can compensate their
+// ShowCaller capture for being invoked through additional layers of
+// method dispatch, such as levelFilter or multiLog. withCallerSkipDelta
+// returns an equivalent logger with its effective CallerSkip increased
+// by delta.
+type callerSkipAdjuster interface {
+	withCallerSkipDelta(delta int) Log
+}
+
+// adjustCallerSkip returns l adjusted by delta if it supports
+// callerSkipAdjuster, or l unchanged otherwise (e.g. JournaldLog, which
+// has no ShowCaller support to adjust).
+func adjustCallerSkip(l Log, delta int) Log {
+	if a, ok := l.(callerSkipAdjuster); ok {
+		return a.withCallerSkipDelta(delta)
+	}
+	return l
+}
+
+// fieldsToKeyvals flattens a fields map into a key/value slice suitable
+// for With.
+func fieldsToKeyvals(fields map[string]interface{}) []interface{} {
+	kv := make([]interface{}, 0, len(fields)*2)
+	for k, v := range fields {
+		kv = append(kv, k, v)
+	}
+	return kv
+}
+
+// mergeFields returns a new fields map containing base plus the
+// key/value pairs in keyvals, without mutating base.
+func mergeFields(base map[string]interface{}, keyvals ...interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(keyvals)/2)
+	for k, v := range base {
+		merged[k] = v
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprintf("%v", keyvals[i])
+		}
+		merged[key] = keyvals[i+1]
+	}
+	return merged
+}
+
+// formatFields renders fields as a sorted " key=value key2=value2"
+// suffix, or "" when there are none.
+func formatFields(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+// callerSkipBase is the runtime.Callers skip that reaches the
+// application code that called a Log method, for the default CallerSkip
+// of 0: it skips runtime.Callers, callerInfo, write and the method.
+const callerSkipBase = 4
+
+// callerInfo returns "file:line func" for the stack frame skip levels
+// up from its own call, or "" if it can't be determined.
+func callerInfo(skip int) string {
+	pc := make([]uintptr, 1)
+	if runtime.Callers(skip, pc) == 0 {
+		return ""
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+	if frame.File == "" {
+		return ""
+	}
+	fn := frame.Function
+	if idx := strings.LastIndex(fn, "/"); idx >= 0 {
+		fn = fn[idx+1:]
+	}
+	return fmt.Sprintf("%s:%d %s", frame.File, frame.Line, fn)
+}
+
+// jsonRecord is the on-the-wire shape written by NewJSONLog and by
+// StdoutLog/SyslogLog when Format is FormatJSON.
+type jsonRecord struct {
+	Time   string                 `json:"time"`
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Caller string                 `json:"caller,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+func renderJSON(level, msg, caller string, fields map[string]interface{}) ([]byte, error) {
+	rec := jsonRecord{
+		Time:   time.Now().Format(time.RFC3339Nano),
+		Level:  level,
+		Msg:    msg,
+		Caller: caller,
+		Fields: fields,
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
 type SyslogLog struct {
-	l *syslog.Writer
+	l         *syslog.Writer
+	Format    Format
+	ShowDebug bool
+
+	// ShowCaller prepends "file:line func" to every emitted line. When
+	// CallerDebugOnly is also set, only Debug records are annotated, so
+	// hot paths logging at Info/Warning/Error don't pay the runtime.Callers
+	// cost.
+	ShowCaller      bool
+	CallerSkip      int
+	CallerDebugOnly bool
+
+	fields map[string]interface{}
 }
 
 func NewLog(label string) (Log, error) {
 	log, err := syslog.New(syslog.LOG_ERR, label)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error establishing syslog. %v", err)
+		fmt.Fprintf(os.Stderr, "Error establishing syslog: %v\n", err)
 		return nil, err
 	}
 	return &SyslogLog{l: log}, nil
 }
 
+// NewLogDebug is like NewLog, but with ShowDebug set so Debug records are
+// also sent.
+func NewLogDebug(label string) (Log, error) {
+	log, err := syslog.New(syslog.LOG_ERR, label)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error establishing syslog: %v\n", err)
+		return nil, err
+	}
+	return &SyslogLog{l: log, ShowDebug: true}, nil
+}
+
 func (l *SyslogLog) Close() {
 	l.l.Close()
 }
 
+func (l *SyslogLog) With(keyvals ...interface{}) Log {
+	nl := *l
+	nl.fields = mergeFields(l.fields, keyvals...)
+	return &nl
+}
+
+func (l *SyslogLog) withCallerSkipDelta(delta int) Log {
+	nl := *l
+	nl.CallerSkip += delta
+	return &nl
+}
+
+func (l *SyslogLog) fieldsMap() map[string]interface{} {
+	return l.fields
+}
+
+func (l *SyslogLog) write(level string, send func(string) error, fields map[string]interface{}, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	caller := ""
+	if l.ShowCaller && (!l.CallerDebugOnly || level == "DEBUG") {
+		caller = callerInfo(callerSkipBase + l.CallerSkip)
+	}
+	if l.Format == FormatJSON {
+		b, err := renderJSON(level, msg, caller, fields)
+		if err != nil {
+			return err
+		}
+		return send(string(b))
+	}
+	if caller != "" {
+		return send(caller + " " + level + ": " + msg + formatFields(fields))
+	}
+	return send(level + ": " + msg + formatFields(fields))
+}
+
+// contextOrOwnFields returns l's fields merged with those of the logger
+// stashed on ctx, if any, without adopting that logger's backend.
+func (l *SyslogLog) contextOrOwnFields(ctx context.Context) map[string]interface{} {
+	if ctxFields := contextFields(ctx); len(ctxFields) > 0 {
+		return mergeFields(l.fields, fieldsToKeyvals(ctxFields)...)
+	}
+	return l.fields
+}
+
 func (l *SyslogLog) Debug(format string, a ...interface{}) error {
-	s := fmt.Sprintf("DEBUG: "+format, a...)
-	return l.l.Debug(s)
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.l.Debug, l.fields, format, a...)
 }
 
 func (l *SyslogLog) Info(format string, a ...interface{}) error {
-	s := fmt.Sprintf("INFO: "+format, a...)
-	return l.l.Info(s)
+	return l.write("INFO", l.l.Info, l.fields, format, a...)
 }
 
 func (l *SyslogLog) Notice(format string, a ...interface{}) error {
-	s := fmt.Sprintf("NOTICE: "+format, a...)
-	return l.l.Notice(s)
+	return l.write("NOTICE", l.l.Notice, l.fields, format, a...)
 }
 
 func (l *SyslogLog) Warning(format string, a ...interface{}) error {
-	s := fmt.Sprintf("WARNING: "+format, a...)
-	return l.l.Warning(s)
+	return l.write("WARNING", l.l.Warning, l.fields, format, a...)
 }
 
 func (l *SyslogLog) Error(format string, a ...interface{}) error {
-	s := fmt.Sprintf("ERROR: "+format, a...)
-	return l.l.Err(s)
+	return l.write("ERROR", l.l.Err, l.fields, format, a...)
+}
+
+func (l *SyslogLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.l.Debug, l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *SyslogLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("INFO", l.l.Info, l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *SyslogLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("NOTICE", l.l.Notice, l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *SyslogLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("WARNING", l.l.Warning, l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *SyslogLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("ERROR", l.l.Err, l.contextOrOwnFields(ctx), format, a...)
 }
 
 func NewStdoutLog() Log {
@@ -82,36 +373,1009 @@ func NewStdoutLogDebug() Log {
 	return &StdoutLog{ShowDebug: true}
 }
 
+// NewStdoutLogWithCaller returns a StdoutLog that prepends "file:line
+// func" to every emitted line. skip lets callers whose logging goes
+// through their own wrapper functions stay transparent in the reported
+// location; 0 reports the direct caller of a Log method.
+func NewStdoutLogWithCaller(skip int) Log {
+	return &StdoutLog{ShowCaller: true, CallerSkip: skip}
+}
+
 type StdoutLog struct {
 	ShowDebug bool
+	Format    Format
+
+	// ShowCaller prepends "file:line func" to every emitted line. When
+	// CallerDebugOnly is also set, only Debug records are annotated, so
+	// hot paths logging at Info/Warning/Error don't pay the runtime.Callers
+	// cost.
+	ShowCaller      bool
+	CallerSkip      int
+	CallerDebugOnly bool
+
+	fields map[string]interface{}
 }
 
 func (l *StdoutLog) Close() {
 }
 
-func (l *StdoutLog) Debug(format string, a ...interface{}) error {
-	if l.ShowDebug {
-		fmt.Printf("DEBUG: "+format+"\n", a...)
+func (l *StdoutLog) With(keyvals ...interface{}) Log {
+	nl := *l
+	nl.fields = mergeFields(l.fields, keyvals...)
+	return &nl
+}
+
+func (l *StdoutLog) withCallerSkipDelta(delta int) Log {
+	nl := *l
+	nl.CallerSkip += delta
+	return &nl
+}
+
+func (l *StdoutLog) fieldsMap() map[string]interface{} {
+	return l.fields
+}
+
+func (l *StdoutLog) write(level string, fields map[string]interface{}, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	caller := ""
+	if l.ShowCaller && (!l.CallerDebugOnly || level == "DEBUG") {
+		caller = callerInfo(callerSkipBase + l.CallerSkip)
+	}
+	if l.Format == FormatJSON {
+		b, err := renderJSON(level, msg, caller, fields)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if caller != "" {
+		fmt.Printf("%s %s: %s%s\n", caller, level, msg, formatFields(fields))
+		return nil
 	}
+	fmt.Printf("%s: %s%s\n", level, msg, formatFields(fields))
 	return nil
 }
 
+// contextOrOwnFields returns l's fields merged with those of the logger
+// stashed on ctx, if any, without adopting that logger's backend.
+func (l *StdoutLog) contextOrOwnFields(ctx context.Context) map[string]interface{} {
+	if ctxFields := contextFields(ctx); len(ctxFields) > 0 {
+		return mergeFields(l.fields, fieldsToKeyvals(ctxFields)...)
+	}
+	return l.fields
+}
+
+func (l *StdoutLog) Debug(format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.fields, format, a...)
+}
+
 func (l *StdoutLog) Info(format string, a ...interface{}) error {
-	fmt.Printf("INFO: "+format+"\n", a...)
-	return nil
+	return l.write("INFO", l.fields, format, a...)
 }
 
 func (l *StdoutLog) Notice(format string, a ...interface{}) error {
-	fmt.Printf("NOTICE: "+format+"\n", a...)
-	return nil
+	return l.write("NOTICE", l.fields, format, a...)
 }
 
 func (l *StdoutLog) Warning(format string, a ...interface{}) error {
-	fmt.Printf("WARNING: "+format+"\n", a...)
-	return nil
+	return l.write("WARNING", l.fields, format, a...)
 }
 
 func (l *StdoutLog) Error(format string, a ...interface{}) error {
-	fmt.Printf("ERROR: "+format+"\n", a...)
+	return l.write("ERROR", l.fields, format, a...)
+}
+
+func (l *StdoutLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *StdoutLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("INFO", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *StdoutLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("NOTICE", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *StdoutLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("WARNING", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *StdoutLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("ERROR", l.contextOrOwnFields(ctx), format, a...)
+}
+
+// NewJSONLog returns a Log that writes one JSON object per line to w,
+// with "time", "level", "msg" and any fields attached via With. Debug
+// records are discarded unless ShowDebug is set on the returned
+// *JSONLog.
+func NewJSONLog(w io.Writer) Log {
+	return &JSONLog{w: w}
+}
+
+func NewJSONLogDebug(w io.Writer) Log {
+	return &JSONLog{w: w, ShowDebug: true}
+}
+
+type JSONLog struct {
+	w         io.Writer
+	ShowDebug bool
+
+	// ShowCaller populates the "caller" field with "file:line func". When
+	// CallerDebugOnly is also set, only Debug records are annotated, so
+	// hot paths logging at Info/Warning/Error don't pay the runtime.Callers
+	// cost.
+	ShowCaller      bool
+	CallerSkip      int
+	CallerDebugOnly bool
+
+	fields map[string]interface{}
+	mu     sync.Mutex
+}
+
+func (l *JSONLog) Close() {
+}
+
+func (l *JSONLog) withCallerSkipDelta(delta int) Log {
+	return &JSONLog{
+		w:               l.w,
+		ShowDebug:       l.ShowDebug,
+		ShowCaller:      l.ShowCaller,
+		CallerSkip:      l.CallerSkip + delta,
+		CallerDebugOnly: l.CallerDebugOnly,
+		fields:          l.fields,
+	}
+}
+
+func (l *JSONLog) With(keyvals ...interface{}) Log {
+	return &JSONLog{
+		w:               l.w,
+		ShowDebug:       l.ShowDebug,
+		ShowCaller:      l.ShowCaller,
+		CallerSkip:      l.CallerSkip,
+		CallerDebugOnly: l.CallerDebugOnly,
+		fields:          mergeFields(l.fields, keyvals...),
+	}
+}
+
+func (l *JSONLog) fieldsMap() map[string]interface{} {
+	return l.fields
+}
+
+func (l *JSONLog) write(level string, fields map[string]interface{}, format string, a ...interface{}) error {
+	caller := ""
+	if l.ShowCaller && (!l.CallerDebugOnly || level == "DEBUG") {
+		caller = callerInfo(callerSkipBase + l.CallerSkip)
+	}
+	b, err := renderJSON(level, fmt.Sprintf(format, a...), caller, fields)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(b)
+	return err
+}
+
+// contextOrOwnFields returns l's fields merged with those of the logger
+// stashed on ctx, if any, without adopting that logger's backend.
+func (l *JSONLog) contextOrOwnFields(ctx context.Context) map[string]interface{} {
+	if ctxFields := contextFields(ctx); len(ctxFields) > 0 {
+		return mergeFields(l.fields, fieldsToKeyvals(ctxFields)...)
+	}
+	return l.fields
+}
+
+func (l *JSONLog) Debug(format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.fields, format, a...)
+}
+
+func (l *JSONLog) Info(format string, a ...interface{}) error {
+	return l.write("INFO", l.fields, format, a...)
+}
+
+func (l *JSONLog) Notice(format string, a ...interface{}) error {
+	return l.write("NOTICE", l.fields, format, a...)
+}
+
+func (l *JSONLog) Warning(format string, a ...interface{}) error {
+	return l.write("WARNING", l.fields, format, a...)
+}
+
+func (l *JSONLog) Error(format string, a ...interface{}) error {
+	return l.write("ERROR", l.fields, format, a...)
+}
+
+func (l *JSONLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JSONLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("INFO", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JSONLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("NOTICE", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JSONLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("WARNING", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JSONLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("ERROR", l.contextOrOwnFields(ctx), format, a...)
+}
+
+// journaldSocketPath is where systemd exposes the native journal
+// protocol socket.
+const journaldSocketPath = "/run/systemd/journal/socket"
+
+// GetLogger probes the host environment and returns the best backend
+// available, in order of preference: journald, syslog, a FileLog at the
+// first of logpaths that can be opened, then StdoutLog. Applications that just want "log
+// somewhere sensible" without hard-coding a backend can call this
+// instead of picking a constructor themselves.
+func GetLogger(enableDebug bool, label string, logpaths ...string) (Log, error) {
+	if _, err := os.Stat(journaldSocketPath); err == nil {
+		if enableDebug {
+			if l, err := NewJournaldLogDebug(label); err == nil {
+				return l, nil
+			}
+			// systemd also owns /dev/log on these hosts, so routing through
+			// syslog still lands in the journal.
+			if l, err := NewLogDebug(label); err == nil {
+				return l, nil
+			}
+		} else {
+			if l, err := NewJournaldLog(label); err == nil {
+				return l, nil
+			}
+			if l, err := NewLog(label); err == nil {
+				return l, nil
+			}
+		}
+	}
+
+	if _, err := os.Stat("/dev/log"); err == nil {
+		if enableDebug {
+			if l, err := NewLogDebug(label); err == nil {
+				return l, nil
+			}
+		} else if l, err := NewLog(label); err == nil {
+			return l, nil
+		}
+	}
+
+	for _, path := range logpaths {
+		l, err := NewFileLog(path, FileLogOptions{})
+		if err != nil {
+			continue
+		}
+		if fl, ok := l.(*FileLog); ok {
+			fl.ShowDebug = enableDebug
+		}
+		return l, nil
+	}
+
+	if enableDebug {
+		return NewStdoutLogDebug(), nil
+	}
+	return NewStdoutLog(), nil
+}
+
+// journalPriority maps our levels onto syslog(3) priority numbers, the
+// scale the journal's PRIORITY= field uses.
+func journalPriority(level string) int {
+	switch level {
+	case "DEBUG":
+		return 7
+	case "INFO":
+		return 6
+	case "NOTICE":
+		return 5
+	case "WARNING":
+		return 4
+	default: // ERROR
+		return 3
+	}
+}
+
+// journalFieldName converts a structured field key into a name journald
+// will accept: uppercase ASCII letters, digits and underscores, not
+// starting with an underscore (those are reserved for trusted fields) or
+// a digit.
+func journalFieldName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := strings.TrimLeft(b.String(), "_")
+	if name == "" {
+		return "FIELD"
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "F" + name
+	}
+	return name
+}
+
+// NewJournaldLog dials the systemd journal's native protocol socket and
+// returns a Log that writes records directly to it, bypassing syslog.
+// Debug/Info/Notice/Warning/Error map to PRIORITY= 7/6/5/4/3,
+// SYSLOG_IDENTIFIER is set to label, and any fields attached via With
+// are passed through as journal fields, their names converted by
+// journalFieldName; a field whose converted name is MESSAGE, PRIORITY or
+// SYSLOG_IDENTIFIER is dropped.
+//
+// Very large records (bigger than the kernel's datagram limit) will
+// fail to send; this implementation doesn't carry the memfd/SCM_RIGHTS
+// fallback systemd uses for those.
+func NewJournaldLog(label string) (Log, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldLog{label: label, conn: conn}, nil
+}
+
+// NewJournaldLogDebug is like NewJournaldLog, but with ShowDebug set so
+// Debug records are also sent.
+func NewJournaldLogDebug(label string) (Log, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournaldLog{label: label, conn: conn, ShowDebug: true}, nil
+}
+
+type JournaldLog struct {
+	label     string
+	conn      *net.UnixConn
+	ShowDebug bool
+	fields    map[string]interface{}
+	mu        sync.Mutex
+}
+
+func (l *JournaldLog) Close() {
+	l.conn.Close()
+}
+
+func (l *JournaldLog) With(keyvals ...interface{}) Log {
+	return &JournaldLog{
+		label:     l.label,
+		conn:      l.conn,
+		ShowDebug: l.ShowDebug,
+		fields:    mergeFields(l.fields, keyvals...),
+	}
+}
+
+func (l *JournaldLog) fieldsMap() map[string]interface{} {
+	return l.fields
+}
+
+// send writes one journal record built from vars to the socket, using
+// the simple "KEY=value" encoding, or the length-prefixed binary
+// encoding for values containing a newline, per the native protocol.
+func (l *JournaldLog) send(vars map[string]string) error {
+	var buf bytes.Buffer
+	for k, v := range vars {
+		if strings.ContainsRune(v, '\n') {
+			buf.WriteString(k)
+			buf.WriteByte('\n')
+			binary.Write(&buf, binary.LittleEndian, uint64(len(v)))
+			buf.WriteString(v)
+		} else {
+			buf.WriteString(k)
+			buf.WriteByte('=')
+			buf.WriteString(v)
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.conn.Write(buf.Bytes())
+	return err
+}
+
+func (l *JournaldLog) write(level string, fields map[string]interface{}, format string, a ...interface{}) error {
+	vars := map[string]string{
+		"MESSAGE":           fmt.Sprintf(format, a...),
+		"PRIORITY":          strconv.Itoa(journalPriority(level)),
+		"SYSLOG_IDENTIFIER": l.label,
+	}
+	for k, v := range fields {
+		name := journalFieldName(k)
+		// MESSAGE/PRIORITY/SYSLOG_IDENTIFIER are set above from the log
+		// call itself; a user field that happens to upper-case to one of
+		// them must not clobber it.
+		if name == "MESSAGE" || name == "PRIORITY" || name == "SYSLOG_IDENTIFIER" {
+			continue
+		}
+		vars[name] = fmt.Sprintf("%v", v)
+	}
+	return l.send(vars)
+}
+
+// contextOrOwnFields returns l's fields merged with those of the logger
+// stashed on ctx, if any, without adopting that logger's backend.
+func (l *JournaldLog) contextOrOwnFields(ctx context.Context) map[string]interface{} {
+	if ctxFields := contextFields(ctx); len(ctxFields) > 0 {
+		return mergeFields(l.fields, fieldsToKeyvals(ctxFields)...)
+	}
+	return l.fields
+}
+
+func (l *JournaldLog) Debug(format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.fields, format, a...)
+}
+
+func (l *JournaldLog) Info(format string, a ...interface{}) error {
+	return l.write("INFO", l.fields, format, a...)
+}
+
+func (l *JournaldLog) Notice(format string, a ...interface{}) error {
+	return l.write("NOTICE", l.fields, format, a...)
+}
+
+func (l *JournaldLog) Warning(format string, a ...interface{}) error {
+	return l.write("WARNING", l.fields, format, a...)
+}
+
+func (l *JournaldLog) Error(format string, a ...interface{}) error {
+	return l.write("ERROR", l.fields, format, a...)
+}
+
+func (l *JournaldLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JournaldLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("INFO", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JournaldLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("NOTICE", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JournaldLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("WARNING", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *JournaldLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("ERROR", l.contextOrOwnFields(ctx), format, a...)
+}
+
+// Level is a log severity, ordered from least to most severe, for use
+// with NewLevelFilter and anywhere an application wants to wire a log
+// threshold from a CLI flag or environment variable.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelNotice
+	LevelWarning
+	LevelError
+)
+
+func (lv Level) String() string {
+	switch lv {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelNotice:
+		return "notice"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", int(lv))
+	}
+}
+
+// Parse converts a level name such as "debug", "info", "notice",
+// "warning" (or "warn") or "error" into a Level. Matching is
+// case-insensitive.
+func Parse(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "notice":
+		return LevelNotice, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("log: unknown level %q", s)
+}
+
+// multiLogCallerSkipDelta is the number of extra stack frames a call
+// passes through before reaching a member backend's write, relative to
+// calling that backend directly: multiLog's own method, call, and the
+// closure call passed to it.
+const multiLogCallerSkipDelta = 3
+
+// NewMultiLog returns a Log that fans every call out to each of logs,
+// e.g. to write to stdout, syslog and a file at once. Close closes all
+// of them; the first non-nil error from any backend is returned from
+// each call. Member backends with ShowCaller set still report the
+// application's call site rather than a frame inside multiLog.
+func NewMultiLog(logs ...Log) Log {
+	adjusted := make([]Log, len(logs))
+	for i, l := range logs {
+		adjusted[i] = adjustCallerSkip(l, multiLogCallerSkipDelta)
+	}
+	return &multiLog{logs: adjusted}
+}
+
+type multiLog struct {
+	logs []Log
+}
+
+func (m *multiLog) Close() {
+	for _, l := range m.logs {
+		l.Close()
+	}
+}
+
+func (m *multiLog) With(keyvals ...interface{}) Log {
+	logs := make([]Log, len(m.logs))
+	for i, l := range m.logs {
+		logs[i] = l.With(keyvals...)
+	}
+	return &multiLog{logs: logs}
+}
+
+func (m *multiLog) withCallerSkipDelta(delta int) Log {
+	logs := make([]Log, len(m.logs))
+	for i, l := range m.logs {
+		logs[i] = adjustCallerSkip(l, delta)
+	}
+	return &multiLog{logs: logs}
+}
+
+func (m *multiLog) call(fn func(Log) error) error {
+	var firstErr error
+	for _, l := range m.logs {
+		if err := fn(l); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *multiLog) Debug(format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.Debug(format, a...) })
+}
+
+func (m *multiLog) Info(format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.Info(format, a...) })
+}
+
+func (m *multiLog) Notice(format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.Notice(format, a...) })
+}
+
+func (m *multiLog) Warning(format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.Warning(format, a...) })
+}
+
+func (m *multiLog) Error(format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.Error(format, a...) })
+}
+
+func (m *multiLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.DebugContext(ctx, format, a...) })
+}
+
+func (m *multiLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.InfoContext(ctx, format, a...) })
+}
+
+func (m *multiLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.NoticeContext(ctx, format, a...) })
+}
+
+func (m *multiLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.WarningContext(ctx, format, a...) })
+}
+
+func (m *multiLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return m.call(func(l Log) error { return l.ErrorContext(ctx, format, a...) })
+}
+
+// levelFilterCallerSkipDelta is the one extra stack frame a call passes
+// through before reaching inner's write, relative to calling inner
+// directly: levelFilter's own method.
+const levelFilterCallerSkipDelta = 1
+
+// NewLevelFilter wraps inner so that records below min are dropped
+// before reaching it. If inner has ShowCaller set, it still reports the
+// application's call site rather than a frame inside levelFilter.
+func NewLevelFilter(inner Log, min Level) Log {
+	return &levelFilter{inner: adjustCallerSkip(inner, levelFilterCallerSkipDelta), min: min}
+}
+
+type levelFilter struct {
+	inner Log
+	min   Level
+}
+
+func (f *levelFilter) Close() {
+	f.inner.Close()
+}
+
+func (f *levelFilter) With(keyvals ...interface{}) Log {
+	return &levelFilter{inner: f.inner.With(keyvals...), min: f.min}
+}
+
+func (f *levelFilter) withCallerSkipDelta(delta int) Log {
+	return &levelFilter{inner: adjustCallerSkip(f.inner, delta), min: f.min}
+}
+
+func (f *levelFilter) Debug(format string, a ...interface{}) error {
+	if f.min > LevelDebug {
+		return nil
+	}
+	return f.inner.Debug(format, a...)
+}
+
+func (f *levelFilter) Info(format string, a ...interface{}) error {
+	if f.min > LevelInfo {
+		return nil
+	}
+	return f.inner.Info(format, a...)
+}
+
+func (f *levelFilter) Notice(format string, a ...interface{}) error {
+	if f.min > LevelNotice {
+		return nil
+	}
+	return f.inner.Notice(format, a...)
+}
+
+func (f *levelFilter) Warning(format string, a ...interface{}) error {
+	if f.min > LevelWarning {
+		return nil
+	}
+	return f.inner.Warning(format, a...)
+}
+
+func (f *levelFilter) Error(format string, a ...interface{}) error {
+	if f.min > LevelError {
+		return nil
+	}
+	return f.inner.Error(format, a...)
+}
+
+func (f *levelFilter) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if f.min > LevelDebug {
+		return nil
+	}
+	return f.inner.DebugContext(ctx, format, a...)
+}
+
+func (f *levelFilter) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	if f.min > LevelInfo {
+		return nil
+	}
+	return f.inner.InfoContext(ctx, format, a...)
+}
+
+func (f *levelFilter) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	if f.min > LevelNotice {
+		return nil
+	}
+	return f.inner.NoticeContext(ctx, format, a...)
+}
+
+func (f *levelFilter) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	if f.min > LevelWarning {
+		return nil
+	}
+	return f.inner.WarningContext(ctx, format, a...)
+}
+
+func (f *levelFilter) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	if f.min > LevelError {
+		return nil
+	}
+	return f.inner.ErrorContext(ctx, format, a...)
+}
+
+// FileLogOptions controls rotation for NewFileLog.
+type FileLogOptions struct {
+	// MaxSizeBytes rotates the file once it would grow past this size.
+	// Zero disables size-based rotation.
+	MaxSizeBytes int64
+
+	// MaxAgeDays removes rotated backups older than this many days.
+	// Zero keeps backups regardless of age.
+	MaxAgeDays int
+
+	// MaxBackups caps the number of rotated backups kept, oldest first.
+	// Zero keeps all of them.
+	MaxBackups int
+
+	// Compress gzips a backup right after it's rotated.
+	Compress bool
+}
+
+// fileLogState is the mutable, rotation-related state shared by a
+// FileLog and every logger derived from it via With, so they rotate in
+// lockstep instead of each tracking their own file handle.
+type fileLogState struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileLog returns a Log that appends records to path, rotating it
+// according to opts. Rotated files are renamed to "path.YYYYMMDD-HHMMSS"
+// (optionally gzipped to "path.YYYYMMDD-HHMMSS.gz") and old backups
+// beyond opts.MaxBackups or opts.MaxAgeDays are pruned after each
+// rotation.
+func NewFileLog(path string, opts FileLogOptions) (Log, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	return &FileLog{
+		path:  path,
+		opts:  opts,
+		state: &fileLogState{f: f, size: size},
+	}, nil
+}
+
+type FileLog struct {
+	path  string
+	opts  FileLogOptions
+	state *fileLogState
+
+	ShowDebug bool
+	Format    Format
+
+	ShowCaller      bool
+	CallerSkip      int
+	CallerDebugOnly bool
+
+	fields map[string]interface{}
+}
+
+func (l *FileLog) Close() {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+	l.state.f.Close()
+}
+
+func (l *FileLog) With(keyvals ...interface{}) Log {
+	nl := *l
+	nl.fields = mergeFields(l.fields, keyvals...)
+	return &nl
+}
+
+func (l *FileLog) withCallerSkipDelta(delta int) Log {
+	nl := *l
+	nl.CallerSkip += delta
+	return &nl
+}
+
+func (l *FileLog) fieldsMap() map[string]interface{} {
+	return l.fields
+}
+
+func (l *FileLog) write(level string, fields map[string]interface{}, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	caller := ""
+	if l.ShowCaller && (!l.CallerDebugOnly || level == "DEBUG") {
+		caller = callerInfo(callerSkipBase + l.CallerSkip)
+	}
+
+	var line []byte
+	if l.Format == FormatJSON {
+		b, err := renderJSON(level, msg, caller, fields)
+		if err != nil {
+			return err
+		}
+		line = b
+	} else {
+		text := level + ": " + msg + formatFields(fields)
+		if caller != "" {
+			text = caller + " " + text
+		}
+		line = []byte(text + "\n")
+	}
+
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+	if err := l.rotateIfNeeded(int64(len(line))); err != nil {
+		return err
+	}
+	n, err := l.state.f.Write(line)
+	l.state.size += int64(n)
+	return err
+}
+
+// rotateIfNeeded must be called with l.state.mu held.
+func (l *FileLog) rotateIfNeeded(nextLen int64) error {
+	if l.opts.MaxSizeBytes <= 0 || l.state.size+nextLen <= l.opts.MaxSizeBytes {
+		return nil
+	}
+	return l.rotate()
+}
+
+// rotate must be called with l.state.mu held.
+func (l *FileLog) rotate() error {
+	if err := l.state.f.Close(); err != nil {
+		return err
+	}
+
+	backupPath := l.path + "." + time.Now().Format("20060102-150405")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			break
+		}
+		backupPath = fmt.Sprintf("%s.%s-%d", l.path, time.Now().Format("20060102-150405"), i)
+	}
+	if err := os.Rename(l.path, backupPath); err != nil {
+		return err
+	}
+	if l.opts.Compress {
+		if err := gzipAndRemove(backupPath); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	l.state.f = f
+	l.state.size = 0
+
+	l.pruneBackups()
 	return nil
 }
+
+// pruneBackups removes rotated backups beyond opts.MaxAgeDays and
+// opts.MaxBackups. It must be called with l.state.mu held.
+func (l *FileLog) pruneBackups() {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	sort.Strings(matches) // backup suffixes are timestamps, so this is oldest-first
+
+	if l.opts.MaxAgeDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -l.opts.MaxAgeDays)
+		kept := matches[:0]
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(m)
+				continue
+			}
+			kept = append(kept, m)
+		}
+		matches = kept
+	}
+
+	if l.opts.MaxBackups > 0 && len(matches) > l.opts.MaxBackups {
+		for _, m := range matches[:len(matches)-l.opts.MaxBackups] {
+			os.Remove(m)
+		}
+	}
+}
+
+// gzipAndRemove compresses path to path+".gz" and removes the
+// uncompressed original.
+func gzipAndRemove(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	if _, err := gw.Write(data); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// contextOrOwnFields returns l's fields merged with those of the logger
+// stashed on ctx, if any, without adopting that logger's backend.
+func (l *FileLog) contextOrOwnFields(ctx context.Context) map[string]interface{} {
+	if ctxFields := contextFields(ctx); len(ctxFields) > 0 {
+		return mergeFields(l.fields, fieldsToKeyvals(ctxFields)...)
+	}
+	return l.fields
+}
+
+func (l *FileLog) Debug(format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.fields, format, a...)
+}
+
+func (l *FileLog) Info(format string, a ...interface{}) error {
+	return l.write("INFO", l.fields, format, a...)
+}
+
+func (l *FileLog) Notice(format string, a ...interface{}) error {
+	return l.write("NOTICE", l.fields, format, a...)
+}
+
+func (l *FileLog) Warning(format string, a ...interface{}) error {
+	return l.write("WARNING", l.fields, format, a...)
+}
+
+func (l *FileLog) Error(format string, a ...interface{}) error {
+	return l.write("ERROR", l.fields, format, a...)
+}
+
+func (l *FileLog) DebugContext(ctx context.Context, format string, a ...interface{}) error {
+	if !l.ShowDebug {
+		return nil
+	}
+	return l.write("DEBUG", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *FileLog) InfoContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("INFO", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *FileLog) NoticeContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("NOTICE", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *FileLog) WarningContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("WARNING", l.contextOrOwnFields(ctx), format, a...)
+}
+
+func (l *FileLog) ErrorContext(ctx context.Context, format string, a ...interface{}) error {
+	return l.write("ERROR", l.contextOrOwnFields(ctx), format, a...)
+}
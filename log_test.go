@@ -0,0 +1,428 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLogRotatesOnSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	l, err := NewFileLog(path, FileLogOptions{MaxSizeBytes: 20})
+	if err != nil {
+		t.Fatalf("NewFileLog: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := l.Info("message %d", i); err != nil {
+			t.Fatalf("Info: %v", err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(backups) == 0 {
+		t.Fatalf("expected at least one rotated backup, got none")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected current log file to still exist: %v", err)
+	}
+}
+
+func TestFileLogPrunesMaxBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	l, err := NewFileLog(path, FileLogOptions{MaxSizeBytes: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("NewFileLog: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 6; i++ {
+		if err := l.Info("message %d", i); err != nil {
+			t.Fatalf("Info: %v", err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(backups) > 2 {
+		t.Fatalf("expected at most 2 backups after pruning, got %d: %v", len(backups), backups)
+	}
+}
+
+func TestFileLogDebugGatedByShowDebug(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	l, err := NewFileLog(path, FileLogOptions{})
+	if err != nil {
+		t.Fatalf("NewFileLog: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Debug("should be dropped"); err != nil {
+		t.Fatalf("Debug: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("expected no output with ShowDebug unset, got %q", data)
+	}
+}
+
+func TestJSONLogContextUsesReceiverBackend(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLog(&buf)
+
+	ctxLogger := NewStdoutLog()
+	ctx := NewContext(context.Background(), ctxLogger)
+
+	if err := l.InfoContext(ctx, "hello"); err != nil {
+		t.Fatalf("InfoContext: %v", err)
+	}
+
+	if buf.Len() == 0 {
+		t.Fatalf("expected InfoContext to write through the receiver (JSONLog), but nothing was written")
+	}
+}
+
+func TestContextDispatchMergesFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLog(&buf)
+
+	ctxLogger := l.With("req_id", "abc")
+	ctx := NewContext(context.Background(), ctxLogger)
+
+	if err := l.InfoContext(ctx, "hello"); err != nil {
+		t.Fatalf("InfoContext: %v", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"req_id":"abc"`)) {
+		t.Fatalf("expected output to carry req_id field from context logger, got %q", buf.String())
+	}
+}
+
+func TestMultiLogFansOutContextCalls(t *testing.T) {
+	var a, b bytes.Buffer
+	m := NewMultiLog(NewJSONLog(&a), NewJSONLog(&b))
+
+	unrelated := NewContext(context.Background(), NewStdoutLog())
+	if err := m.InfoContext(unrelated, "hello"); err != nil {
+		t.Fatalf("InfoContext: %v", err)
+	}
+
+	if a.Len() == 0 || b.Len() == 0 {
+		t.Fatalf("expected both multiLog members to receive the record, got a=%q b=%q", a.String(), b.String())
+	}
+}
+
+func TestShowCallerReportsCallSite(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLog(&buf).(*JSONLog)
+	l.ShowCaller = true
+
+	if err := l.Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("log_test.go")) {
+		t.Fatalf("expected caller to point at this test file, got %q", buf.String())
+	}
+}
+
+func TestCallerDebugOnlySkipsNonDebugLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLog(&buf).(*JSONLog)
+	l.ShowDebug = true
+	l.ShowCaller = true
+	l.CallerDebugOnly = true
+
+	if err := l.Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`"caller"`)) {
+		t.Fatalf("expected no caller field on Info with CallerDebugOnly set, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := l.Debug("hello"); err != nil {
+		t.Fatalf("Debug: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"caller"`)) {
+		t.Fatalf("expected a caller field on Debug with CallerDebugOnly set, got %q", buf.String())
+	}
+}
+
+func TestCallerSkipCompensatesForWrapperFunction(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLog(&buf).(*JSONLog)
+	l.ShowCaller = true
+	l.CallerSkip = 1
+
+	logViaWrapper := func() error { return l.Info("hello") }
+	if err := logViaWrapper(); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	// The closure is reported as "...ForWrapperFunction.func1" when it is
+	// not skipped.
+	if bytes.Contains(buf.Bytes(), []byte(".func1")) {
+		t.Fatalf("expected CallerSkip to skip past the wrapper closure, got %q", buf.String())
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("log_test.go")) {
+		t.Fatalf("expected caller to still point at this test file, got %q", buf.String())
+	}
+}
+
+// skipIfSystemLogPresent skips the test on hosts where GetLogger would
+// pick journald or syslog ahead of the file/stdout branches under test.
+func skipIfSystemLogPresent(t *testing.T) {
+	t.Helper()
+	if _, err := os.Stat(journaldSocketPath); err == nil {
+		t.Skip("journald socket present on this host")
+	}
+	if _, err := os.Stat("/dev/log"); err == nil {
+		t.Skip("/dev/log present on this host")
+	}
+}
+
+func TestGetLoggerPrefersFileOverStdout(t *testing.T) {
+	skipIfSystemLogPresent(t)
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	l, err := GetLogger(false, "test", path)
+	if err != nil {
+		t.Fatalf("GetLogger: %v", err)
+	}
+	defer l.Close()
+
+	fl, ok := l.(*FileLog)
+	if !ok {
+		t.Fatalf("expected GetLogger to return a *FileLog given a writable logpath, got %T", l)
+	}
+	if fl.ShowDebug {
+		t.Fatalf("expected ShowDebug false when enableDebug is false")
+	}
+}
+
+func TestGetLoggerWiresEnableDebugIntoFile(t *testing.T) {
+	skipIfSystemLogPresent(t)
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	l, err := GetLogger(true, "test", path)
+	if err != nil {
+		t.Fatalf("GetLogger: %v", err)
+	}
+	defer l.Close()
+
+	fl, ok := l.(*FileLog)
+	if !ok {
+		t.Fatalf("expected GetLogger to return a *FileLog given a writable logpath, got %T", l)
+	}
+	if !fl.ShowDebug {
+		t.Fatalf("expected ShowDebug true when enableDebug is true")
+	}
+}
+
+func TestGetLoggerFallsBackToStdout(t *testing.T) {
+	skipIfSystemLogPresent(t)
+
+	l, err := GetLogger(true, "test")
+	if err != nil {
+		t.Fatalf("GetLogger: %v", err)
+	}
+	defer l.Close()
+
+	sl, ok := l.(*StdoutLog)
+	if !ok {
+		t.Fatalf("expected GetLogger to fall back to *StdoutLog with no logpaths, got %T", l)
+	}
+	if !sl.ShowDebug {
+		t.Fatalf("expected ShowDebug true when enableDebug is true")
+	}
+}
+
+// readJournalVars reads one native-protocol journal record off conn and
+// parses it back into a key/value map, understanding only the simple
+// "KEY=value" encoding used for values without embedded newlines.
+func readJournalVars(t *testing.T, conn *net.UnixConn) map[string]string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	vars := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimRight(string(buf[:n]), "\n"), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("unexpected non-simple-encoded line in test record: %q", line)
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+func newTestJournaldLog(t *testing.T) (*JournaldLog, *net.UnixConn) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	addr := &net.UnixAddr{Name: path, Net: "unixgram"}
+
+	server, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	client, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		t.Fatalf("DialUnix: %v", err)
+	}
+
+	return &JournaldLog{label: "testapp", conn: client}, server
+}
+
+func TestJournaldLogSendsExpectedFields(t *testing.T) {
+	l, conn := newTestJournaldLog(t)
+	defer l.Close()
+
+	if err := l.With("user_id", 42).Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	vars := readJournalVars(t, conn)
+	if vars["MESSAGE"] != "hello" {
+		t.Fatalf("expected MESSAGE=hello, got %q", vars["MESSAGE"])
+	}
+	if vars["PRIORITY"] != "6" {
+		t.Fatalf("expected PRIORITY=6 (INFO), got %q", vars["PRIORITY"])
+	}
+	if vars["SYSLOG_IDENTIFIER"] != "testapp" {
+		t.Fatalf("expected SYSLOG_IDENTIFIER=testapp, got %q", vars["SYSLOG_IDENTIFIER"])
+	}
+	if vars["USER_ID"] != "42" {
+		t.Fatalf("expected USER_ID=42, got %q", vars["USER_ID"])
+	}
+}
+
+func TestJournaldLogReservedFieldsCannotBeOverridden(t *testing.T) {
+	l, conn := newTestJournaldLog(t)
+	defer l.Close()
+
+	if err := l.With("message", "INJECTED", "priority", "0", "syslog_identifier", "evil").Info("original message"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	vars := readJournalVars(t, conn)
+	if vars["MESSAGE"] != "original message" {
+		t.Fatalf("a user field colliding with MESSAGE must not override it, got %q", vars["MESSAGE"])
+	}
+	if vars["PRIORITY"] != "6" {
+		t.Fatalf("a user field colliding with PRIORITY must not override it, got %q", vars["PRIORITY"])
+	}
+	if vars["SYSLOG_IDENTIFIER"] != "testapp" {
+		t.Fatalf("a user field colliding with SYSLOG_IDENTIFIER must not override it, got %q", vars["SYSLOG_IDENTIFIER"])
+	}
+}
+
+func TestJournaldLogDebugGatedByShowDebug(t *testing.T) {
+	l, conn := newTestJournaldLog(t)
+	defer l.Close()
+
+	if err := l.Debug("should be dropped"); err != nil {
+		t.Fatalf("Debug: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatalf("expected no datagram to be sent with ShowDebug unset")
+	}
+}
+
+func TestMultiLogFansOutPlainCalls(t *testing.T) {
+	var a, b bytes.Buffer
+	m := NewMultiLog(NewJSONLog(&a), NewJSONLog(&b))
+
+	if err := m.Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+
+	if a.Len() == 0 || b.Len() == 0 {
+		t.Fatalf("expected both multiLog members to receive the record, got a=%q b=%q", a.String(), b.String())
+	}
+}
+
+func TestLevelFilterDropsBelowMin(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewLevelFilter(NewJSONLog(&buf), LevelWarning)
+
+	if err := f.Debug("dropped"); err != nil {
+		t.Fatalf("Debug: %v", err)
+	}
+	if err := f.Info("dropped"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected Debug/Info below min to be dropped, got %q", buf.String())
+	}
+
+	if err := f.Warning("kept"); err != nil {
+		t.Fatalf("Warning: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatalf("expected Warning at or above min to pass through")
+	}
+}
+
+func TestLevelFilterAndMultiLogPreserveShowCaller(t *testing.T) {
+	var bufLF, bufA, bufB bytes.Buffer
+
+	lfBase := NewJSONLog(&bufLF).(*JSONLog)
+	lfBase.ShowCaller = true
+	lf := NewLevelFilter(lfBase, LevelDebug)
+	if err := lf.Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if bytes.Contains(bufLF.Bytes(), []byte("levelFilter")) {
+		t.Fatalf("expected NewLevelFilter to compensate CallerSkip so the wrapper itself isn't reported, got %q", bufLF.String())
+	}
+	if !bytes.Contains(bufLF.Bytes(), []byte("log_test.go")) {
+		t.Fatalf("expected caller to point at this test file, got %q", bufLF.String())
+	}
+
+	mlBaseA := NewJSONLog(&bufA).(*JSONLog)
+	mlBaseA.ShowCaller = true
+	mlBaseB := NewJSONLog(&bufB).(*JSONLog)
+	mlBaseB.ShowCaller = true
+	ml := NewMultiLog(mlBaseA, mlBaseB)
+	if err := ml.Info("hello"); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	for _, buf := range []bytes.Buffer{bufA, bufB} {
+		if bytes.Contains(buf.Bytes(), []byte("multiLog")) {
+			t.Fatalf("expected NewMultiLog to compensate CallerSkip so the wrapper itself isn't reported, got %q", buf.String())
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("log_test.go")) {
+			t.Fatalf("expected caller to point at this test file, got %q", buf.String())
+		}
+	}
+}